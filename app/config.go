@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the effective application configuration: whatever the JSON file
+// at APP_CONFIG contains, with every individual env var still winning on
+// top of it. Keeping the env vars authoritative means existing deployments
+// that only set env vars keep working untouched.
+type Config struct {
+	Addr string
+
+	DBDriver string
+	DBDSN    string
+	DBPath   string
+	// DBMaxOpenConns/DBMaxIdleConns are nil when neither env nor file set
+	// them, meaning "leave the driver's default alone" -- distinct from an
+	// operator explicitly overriding with 0.
+	DBMaxOpenConns *int
+	DBMaxIdleConns *int
+
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	ProxyHeader    string
+	TrustedProxies string
+	AdminToken     string
+
+	IngestToken          string
+	IngestRatePerMin     float64
+	IngestBurst          float64
+	IngestRateLRUSize    int
+	IngestMaxConcurrency int
+
+	GeoIPDBPath    string
+	GeoIPASNDBPath string
+	GeoIPCacheSize int
+
+	Sources []tautulliSourceFile
+}
+
+// configFile mirrors the on-disk JSON shape. Every field is optional; a
+// missing section just means its settings come entirely from env vars.
+type configFile struct {
+	Database struct {
+		Driver       string `json:"driver"`
+		DSN          string `json:"dsn"`
+		Path         string `json:"path"`
+		MaxOpenConns *int   `json:"max_open_conns"`
+		MaxIdleConns *int   `json:"max_idle_conns"`
+	} `json:"database"`
+	HTTP struct {
+		Addr    string `json:"addr"`
+		Timeout struct {
+			Read  string `json:"read"`
+			Write string `json:"write"`
+			Idle  string `json:"idle"`
+		} `json:"timeout"`
+		ProxyHeader    string `json:"proxy_header"`
+		TrustedProxies string `json:"trusted_proxies"`
+		AdminToken     string `json:"admin_token"`
+	} `json:"http"`
+	Ingest struct {
+		Token          string   `json:"token"`
+		RatePerMin     *float64 `json:"rate_per_min"`
+		Burst          *float64 `json:"burst"`
+		RateLRUSize    *int     `json:"rate_lru_size"`
+		MaxConcurrency *int     `json:"max_concurrency"`
+	} `json:"ingest"`
+	GeoIP struct {
+		DBPath    string `json:"db_path"`
+		ASNDBPath string `json:"asn_db_path"`
+		CacheSize *int   `json:"cache_size"`
+	} `json:"geoip"`
+	Sources []tautulliSourceFile `json:"sources"`
+}
+
+// loadConfig reads the JSON file at APP_CONFIG (default /data/config.json,
+// missing is fine) and layers env vars on top of it. A malformed config
+// file, or a set-but-unparseable env var, is a startup error, not a silent
+// fallback, so misconfigurations surface immediately instead of quietly
+// running with defaults.
+func loadConfig() (*Config, error) {
+	cfgPath := env("APP_CONFIG", "/data/config.json")
+	var cf configFile
+	data, err := os.ReadFile(cfgPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &cf); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", cfgPath, err)
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("read %s: %w", cfgPath, err)
+	}
+
+	dbMaxOpenConns, err := envIntOrNil("APP_DB_MAX_OPEN_CONNS")
+	if err != nil {
+		return nil, err
+	}
+	dbMaxIdleConns, err := envIntOrNil("APP_DB_MAX_IDLE_CONNS")
+	if err != nil {
+		return nil, err
+	}
+	readTimeout, err := envDurationOrNil("APP_READ_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	writeTimeout, err := envDurationOrNil("APP_WRITE_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	idleTimeout, err := envDurationOrNil("APP_IDLE_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	fileReadTimeout, err := parseDurationOrNil(cf.HTTP.Timeout.Read)
+	if err != nil {
+		return nil, fmt.Errorf("http.timeout.read: %w", err)
+	}
+	fileWriteTimeout, err := parseDurationOrNil(cf.HTTP.Timeout.Write)
+	if err != nil {
+		return nil, fmt.Errorf("http.timeout.write: %w", err)
+	}
+	fileIdleTimeout, err := parseDurationOrNil(cf.HTTP.Timeout.Idle)
+	if err != nil {
+		return nil, fmt.Errorf("http.timeout.idle: %w", err)
+	}
+	ingestRatePerMin, err := envFloatOrNil("INGEST_RATE_PER_MIN")
+	if err != nil {
+		return nil, err
+	}
+	ingestBurst, err := envFloatOrNil("INGEST_BURST")
+	if err != nil {
+		return nil, err
+	}
+	ingestRateLRUSize, err := envIntOrNil("INGEST_RATE_LRU_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	ingestMaxConcurrency, err := envIntOrNil("INGEST_MAX_CONCURRENCY")
+	if err != nil {
+		return nil, err
+	}
+	geoIPCacheSize, err := envIntOrNil("GEOIP_CACHE_SIZE")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Addr: pickString(os.Getenv("APP_ADDR"), cf.HTTP.Addr, "0.0.0.0:1707"),
+
+		DBDriver:       pickString(os.Getenv("APP_DB_DRIVER"), cf.Database.Driver, "sqlite"),
+		DBDSN:          pickString(os.Getenv("APP_DB_DSN"), cf.Database.DSN, ""),
+		DBPath:         pickString(os.Getenv("APP_DB_PATH"), cf.Database.Path, "/data/puipr.db"),
+		DBMaxOpenConns: pickIntPtr(dbMaxOpenConns, cf.Database.MaxOpenConns),
+		DBMaxIdleConns: pickIntPtr(dbMaxIdleConns, cf.Database.MaxIdleConns),
+
+		ReadTimeout:    pickDuration(readTimeout, fileReadTimeout, 10*time.Second),
+		WriteTimeout:   pickDuration(writeTimeout, fileWriteTimeout, 30*time.Second),
+		IdleTimeout:    pickDuration(idleTimeout, fileIdleTimeout, 60*time.Second),
+		ProxyHeader:    pickString(os.Getenv("APP_REAL_IP_HEADER"), cf.HTTP.ProxyHeader, "X-Forwarded-For"),
+		TrustedProxies: pickString(os.Getenv("APP_TRUSTED_PROXIES"), cf.HTTP.TrustedProxies, ""),
+		AdminToken:     pickString(os.Getenv("ADMIN_TOKEN"), cf.HTTP.AdminToken, ""),
+
+		IngestToken:          pickString(os.Getenv("INGEST_TOKEN"), cf.Ingest.Token, ""),
+		IngestRatePerMin:     pickFloat(ingestRatePerMin, cf.Ingest.RatePerMin, 60),
+		IngestBurst:          pickFloat(ingestBurst, cf.Ingest.Burst, 10),
+		IngestRateLRUSize:    pickInt(ingestRateLRUSize, cf.Ingest.RateLRUSize, 4096),
+		IngestMaxConcurrency: pickInt(ingestMaxConcurrency, cf.Ingest.MaxConcurrency, 8),
+
+		GeoIPDBPath:    pickString(os.Getenv("GEOIP_DB_PATH"), cf.GeoIP.DBPath, ""),
+		GeoIPASNDBPath: pickString(os.Getenv("GEOIP_ASN_DB_PATH"), cf.GeoIP.ASNDBPath, ""),
+		GeoIPCacheSize: pickInt(geoIPCacheSize, cf.GeoIP.CacheSize, 4096),
+
+		Sources: cf.Sources,
+	}
+	return cfg, nil
+}
+
+// pickString returns the first non-empty of env, file, then def.
+func pickString(env, file, def string) string {
+	if env != "" {
+		return env
+	}
+	if file != "" {
+		return file
+	}
+	return def
+}
+
+// pickInt returns the first of env, file that was actually set, then def.
+// Unlike a plain "first non-zero" check, this lets an explicitly
+// configured 0 win over a lower-priority source instead of being treated
+// as absent.
+func pickInt(env, file *int, def int) int {
+	if env != nil {
+		return *env
+	}
+	if file != nil {
+		return *file
+	}
+	return def
+}
+
+// pickIntPtr is pickInt for settings with no real default, where "unset"
+// must stay distinguishable from an explicitly configured 0 all the way
+// through to the caller.
+func pickIntPtr(env, file *int) *int {
+	if env != nil {
+		return env
+	}
+	if file != nil {
+		return file
+	}
+	return nil
+}
+
+// pickFloat is pickInt for float64 settings.
+func pickFloat(env, file *float64, def float64) float64 {
+	if env != nil {
+		return *env
+	}
+	if file != nil {
+		return *file
+	}
+	return def
+}
+
+// pickDuration is pickInt for duration settings.
+func pickDuration(env, file *time.Duration, def time.Duration) time.Duration {
+	if env != nil {
+		return *env
+	}
+	if file != nil {
+		return *file
+	}
+	return def
+}
+
+// envIntOrNil reads an int from the environment, returning nil when the var
+// is unset (as opposed to an explicitly set 0). A set-but-malformed value is
+// a startup error, not a silent fallback to the default.
+func envIntOrNil(k string) (*int, error) {
+	v, ok := os.LookupEnv(k)
+	if !ok || v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s=%q: %w", k, v, err)
+	}
+	return &n, nil
+}
+
+// envFloatOrNil is envIntOrNil for float64 env vars.
+func envFloatOrNil(k string) (*float64, error) {
+	v, ok := os.LookupEnv(k)
+	if !ok || v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s=%q: %w", k, v, err)
+	}
+	return &f, nil
+}
+
+// envDurationOrNil is envIntOrNil for duration env vars.
+func envDurationOrNil(k string) (*time.Duration, error) {
+	v, ok := os.LookupEnv(k)
+	if !ok || v == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s=%q: %w", k, v, err)
+	}
+	return &d, nil
+}
+
+// parseDurationOrNil parses s, returning nil (meaning "unset") when empty,
+// since config file durations are optional. A non-empty but unparseable
+// value is a startup error, not a silent fallback to the default.
+func parseDurationOrNil(s string) (*time.Duration, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// loadDotEnv applies KEY=VALUE lines from path to the process environment
+// (godotenv-style), skipping blank lines and #-comments. Variables already
+// set in the real environment are left untouched, so a real env var always
+// wins over the .env file.
+func loadDotEnv(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		val := strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, val)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigEnvWinsOverFile(t *testing.T) {
+	t.Setenv("APP_CONFIG", writeConfigFile(t, `{"ingest":{"rate_lru_size": 100}}`))
+	t.Setenv("INGEST_RATE_LRU_SIZE", "500")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IngestRateLRUSize != 500 {
+		t.Errorf("IngestRateLRUSize = %d, want 500 (env should win over file)", cfg.IngestRateLRUSize)
+	}
+}
+
+func TestLoadConfigFileWinsOverDefault(t *testing.T) {
+	t.Setenv("APP_CONFIG", writeConfigFile(t, `{"ingest":{"rate_lru_size": 100}}`))
+	t.Setenv("INGEST_RATE_LRU_SIZE", "")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IngestRateLRUSize != 100 {
+		t.Errorf("IngestRateLRUSize = %d, want 100 (file should win over default)", cfg.IngestRateLRUSize)
+	}
+}
+
+func TestLoadConfigDefaultWhenNeitherSet(t *testing.T) {
+	t.Setenv("APP_CONFIG", filepath.Join(t.TempDir(), "missing-config.json"))
+	t.Setenv("INGEST_RATE_LRU_SIZE", "")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IngestRateLRUSize != 4096 {
+		t.Errorf("IngestRateLRUSize = %d, want the default 4096", cfg.IngestRateLRUSize)
+	}
+}
+
+func TestLoadConfigExplicitZeroIsRespectedNotTreatedAsUnset(t *testing.T) {
+	t.Setenv("APP_CONFIG", filepath.Join(t.TempDir(), "missing-config.json"))
+	t.Setenv("INGEST_RATE_LRU_SIZE", "0")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IngestRateLRUSize != 0 {
+		t.Errorf("IngestRateLRUSize = %d, want 0: an explicit 0 must not fall back to the default", cfg.IngestRateLRUSize)
+	}
+}
+
+func TestLoadConfigDBMaxIdleConnsDistinguishesUnsetFromExplicitZero(t *testing.T) {
+	t.Setenv("APP_CONFIG", filepath.Join(t.TempDir(), "missing-config.json"))
+	t.Setenv("APP_DB_MAX_IDLE_CONNS", "")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DBMaxIdleConns != nil {
+		t.Errorf("DBMaxIdleConns = %v, want nil when neither env nor file set it", cfg.DBMaxIdleConns)
+	}
+
+	t.Setenv("APP_DB_MAX_IDLE_CONNS", "0")
+	cfg, err = loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DBMaxIdleConns == nil || *cfg.DBMaxIdleConns != 0 {
+		t.Errorf("DBMaxIdleConns = %v, want a pointer to 0: an explicit 0 must not fall back to nil/default", cfg.DBMaxIdleConns)
+	}
+}
+
+func TestLoadConfigRejectsMalformedEnvVar(t *testing.T) {
+	t.Setenv("APP_CONFIG", filepath.Join(t.TempDir(), "missing-config.json"))
+	t.Setenv("APP_READ_TIMEOUT", "notaduration")
+
+	if _, err := loadConfig(); err == nil {
+		t.Error("expected an error for a malformed APP_READ_TIMEOUT, not a silent fallback to the default")
+	}
+}
+
+func TestLoadConfigRejectsMalformedFileDurationValue(t *testing.T) {
+	t.Setenv("APP_CONFIG", writeConfigFile(t, `{"http":{"timeout":{"read":"notaduration"}}}`))
+	t.Setenv("APP_READ_TIMEOUT", "")
+
+	if _, err := loadConfig(); err == nil {
+		t.Error("expected an error for a malformed http.timeout.read in the config file, not a silent fallback to the default")
+	}
+}
+
+func TestLoadConfigRejectsMalformedFile(t *testing.T) {
+	t.Setenv("APP_CONFIG", writeConfigFile(t, `{not json`))
+
+	if _, err := loadConfig(); err == nil {
+		t.Error("expected an error for a malformed config file")
+	}
+}
+
+func TestLoadDotEnvDoesNotOverrideExistingEnv(t *testing.T) {
+	t.Setenv("APP_DOTENV_TEST_VAR", "from-real-env")
+	t.Cleanup(func() { os.Unsetenv("APP_DOTENV_ONLY_VAR") })
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("APP_DOTENV_TEST_VAR=from-dotenv\nAPP_DOTENV_ONLY_VAR=only-in-dotenv\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadDotEnv(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("APP_DOTENV_TEST_VAR"); got != "from-real-env" {
+		t.Errorf("APP_DOTENV_TEST_VAR = %q, want the real env value to win over .env", got)
+	}
+	if got := os.Getenv("APP_DOTENV_ONLY_VAR"); got != "only-in-dotenv" {
+		t.Errorf("APP_DOTENV_ONLY_VAR = %q, want the .env-only value to be applied", got)
+	}
+}
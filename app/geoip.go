@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// geoInfo is the result of a GeoIP/ASN lookup for one IP address.
+type geoInfo struct {
+	Country string
+	City    string
+	ASN     string
+}
+
+// countryFlagEmoji converts an ISO 3166-1 alpha-2 country code into its
+// regional-indicator flag emoji, for display in the summary/user
+// templates. Returns "" for anything that isn't a two-letter code.
+func countryFlagEmoji(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	a, b := code[0]|0x20, code[1]|0x20
+	if a < 'a' || a > 'z' || b < 'a' || b > 'z' {
+		return ""
+	}
+	return string(rune(0x1F1E6+int(a-'a'))) + string(rune(0x1F1E6+int(b-'a')))
+}
+
+// geoIPReader wraps a read-only mmap'd MaxMind City database, plus an
+// optional separate ASN/ISP database (MaxMind ships the two as distinct
+// mmdb files; a City database's ASN() method always errors). Lookups are
+// cached in a size-bounded LRU so replaying the same IP doesn't re-hit the
+// database, and are never fatal: a lookup error just means the IP goes in
+// without enrichment.
+type geoIPReader struct {
+	db    *geoip2.Reader
+	asn   *geoip2.Reader // nil when GEOIP_ASN_DB_PATH isn't set
+	cache *geoLRU
+}
+
+func newGeoIPReader(path, asnPath string, cacheSize int) (*geoIPReader, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var asn *geoip2.Reader
+	if asnPath != "" {
+		asn, err = geoip2.Open(asnPath)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &geoIPReader{db: db, asn: asn, cache: newGeoLRU(cacheSize)}, nil
+}
+
+func (g *geoIPReader) close() error {
+	if g.asn != nil {
+		g.asn.Close()
+	}
+	return g.db.Close()
+}
+
+func (g *geoIPReader) lookup(ipStr string) geoInfo {
+	if info, ok := g.cache.get(ipStr); ok {
+		return info
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return geoInfo{}
+	}
+
+	var info geoInfo
+	if rec, err := g.db.City(ip); err != nil {
+		log.Printf("geoip city lookup %s: %v", ipStr, err)
+	} else {
+		info.Country = rec.Country.IsoCode
+		info.City = rec.City.Names["en"]
+	}
+	if g.asn != nil {
+		if asn, err := g.asn.ASN(ip); err != nil {
+			log.Printf("geoip asn lookup %s: %v", ipStr, err)
+		} else if asn.AutonomousSystemNumber != 0 {
+			info.ASN = fmt.Sprintf("AS%d %s", asn.AutonomousSystemNumber, asn.AutonomousSystemOrganization)
+		}
+	}
+
+	g.cache.put(ipStr, info)
+	return info
+}
+
+// geoLRU is a size-bounded, concurrent-safe LRU cache of geoInfo keyed by
+// IP. It's a thin wrapper over the generic lru shared with bucketLRU.
+type geoLRU struct {
+	lru *lru[geoInfo]
+}
+
+func newGeoLRU(capacity int) *geoLRU {
+	return &geoLRU{lru: newLRU[geoInfo](capacity, 0)}
+}
+
+func (c *geoLRU) get(key string) (geoInfo, bool) { return c.lru.get(key) }
+
+func (c *geoLRU) put(key string, value geoInfo) { c.lru.put(key, value) }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is a size-bounded, concurrent-safe LRU keyed by string. When idleTTL
+// is non-zero, entries that haven't been touched within it are evicted
+// opportunistically on insert, ahead of the capacity-based eviction.
+// bucketLRU and geoLRU are thin, type-specific wrappers over this.
+type lru[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	idleTTL  time.Duration
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key        string
+	value      V
+	lastAccess time.Time
+}
+
+func newLRU[V any](capacity int, idleTTL time.Duration) *lru[V] {
+	return &lru[V]{capacity: capacity, idleTTL: idleTTL, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (l *lru[V]) get(key string) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e := el.Value.(*lruEntry[V])
+	e.lastAccess = time.Now()
+	l.ll.MoveToFront(el)
+	return e.value, true
+}
+
+func (l *lru[V]) put(key string, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := l.items[key]; ok {
+		e := el.Value.(*lruEntry[V])
+		e.value = value
+		e.lastAccess = now
+		l.ll.MoveToFront(el)
+		return
+	}
+
+	l.evictLocked(now)
+	e := &lruEntry[V]{key: key, value: value, lastAccess: now}
+	l.items[key] = l.ll.PushFront(e)
+}
+
+// getOrCreate atomically returns the cached value for key, or creates one
+// via create and inserts it if absent. Kept atomic so two concurrent
+// misses for the same new key can't each insert their own entry.
+func (l *lru[V]) getOrCreate(key string, create func() V) V {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := l.items[key]; ok {
+		e := el.Value.(*lruEntry[V])
+		e.lastAccess = now
+		l.ll.MoveToFront(el)
+		return e.value
+	}
+
+	l.evictLocked(now)
+	v := create()
+	e := &lruEntry[V]{key: key, value: v, lastAccess: now}
+	l.items[key] = l.ll.PushFront(e)
+	return v
+}
+
+func (l *lru[V]) evictLocked(now time.Time) {
+	if l.idleTTL > 0 {
+		l.evictIdleLocked(now)
+	}
+	if l.capacity <= 0 {
+		return // unbounded: capacity<=0 (e.g. an explicit 0 config override) must not spin evictOldestLocked on an empty list
+	}
+	for l.ll.Len() >= l.capacity {
+		l.evictOldestLocked()
+	}
+}
+
+func (l *lru[V]) evictIdleLocked(now time.Time) {
+	for el := l.ll.Back(); el != nil; {
+		e := el.Value.(*lruEntry[V])
+		prev := el.Prev()
+		if now.Sub(e.lastAccess) <= l.idleTTL {
+			break // list is ordered by recency; the rest are newer still
+		}
+		l.ll.Remove(el)
+		delete(l.items, e.key)
+		el = prev
+	}
+}
+
+func (l *lru[V]) evictOldestLocked() {
+	el := l.ll.Back()
+	if el == nil {
+		return
+	}
+	e := el.Value.(*lruEntry[V])
+	l.ll.Remove(el)
+	delete(l.items, e.key)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUPutEvictsOldestOverCapacity(t *testing.T) {
+	l := newLRU[int](2, 0)
+
+	l.put("a", 1)
+	l.put("b", 2)
+	l.put("c", 3) // should evict "a"
+
+	if _, ok := l.get("a"); ok {
+		t.Error("expected \"a\" to have been evicted at capacity")
+	}
+	if v, ok := l.get("b"); !ok || v != 2 {
+		t.Errorf("get(b) = %v, %v, want 2, true", v, ok)
+	}
+}
+
+func TestLRUGetRefreshesRecency(t *testing.T) {
+	l := newLRU[int](2, 0)
+
+	l.put("a", 1)
+	l.put("b", 2)
+	l.get("a")    // touch "a" so "b" becomes the least recently used
+	l.put("c", 3) // should evict "b", not "a"
+
+	if _, ok := l.get("b"); ok {
+		t.Error("expected \"b\" to have been evicted, not \"a\"")
+	}
+	if _, ok := l.get("a"); !ok {
+		t.Error("expected \"a\" to survive: it was touched more recently than \"b\"")
+	}
+}
+
+func TestLRUEvictsIdleEntriesOnInsert(t *testing.T) {
+	l := newLRU[int](10, time.Millisecond)
+
+	l.put("a", 1)
+	time.Sleep(5 * time.Millisecond)
+	l.put("b", 2) // triggers idle eviction of "a"
+
+	if _, ok := l.get("a"); ok {
+		t.Error("expected \"a\" to have been evicted for being idle past idleTTL")
+	}
+}
+
+func TestLRUZeroCapacityIsUnboundedNotStuck(t *testing.T) {
+	l := newLRU[int](0, 0)
+
+	l.put("a", 1)
+	l.put("b", 2)
+
+	if v, ok := l.get("a"); !ok || v != 1 {
+		t.Errorf("get(a) = %v, %v, want 1, true", v, ok)
+	}
+	if v, ok := l.get("b"); !ok || v != 2 {
+		t.Errorf("get(b) = %v, %v, want 2, true", v, ok)
+	}
+}
+
+func TestLRUGetOrCreateOnlyCreatesOnce(t *testing.T) {
+	l := newLRU[int](10, 0)
+	calls := 0
+	create := func() int { calls++; return 42 }
+
+	if v := l.getOrCreate("a", create); v != 42 {
+		t.Errorf("getOrCreate = %d, want 42", v)
+	}
+	if v := l.getOrCreate("a", create); v != 42 {
+		t.Errorf("getOrCreate (cached) = %d, want 42", v)
+	}
+	if calls != 1 {
+		t.Errorf("create called %d times, want 1", calls)
+	}
+}
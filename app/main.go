@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"embed"
 	"encoding/json"
@@ -12,9 +13,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -24,13 +28,12 @@ import (
 var tplFS embed.FS
 
 type Server struct {
-	db  *sql.DB
-	tpl *template.Template
-	// fetcher config
-	tautulliURL    string
-	tautulliAPIKey string
-	tautulliLength int
-	fetchInterval  time.Duration
+	store       Store
+	tpl         *template.Template
+	metrics     *Metrics
+	sources     []tautulliSource
+	geoip       *geoIPReader
+	ingestToken string
 }
 
 type TautulliItem struct {
@@ -40,6 +43,10 @@ type TautulliItem struct {
 	UserThumb    *string `json:"user_thumb"`
 	IPAddress    *string `json:"ip_address"`
 	Date         *int64  `json:"date"`
+	Source       string  `json:"source,omitempty"`
+	Country      string  `json:"-"`
+	City         string  `json:"-"`
+	ASN          string  `json:"-"`
 }
 
 func env(k, def string) string {
@@ -58,27 +65,56 @@ func mustFileDir(p string) {
 }
 
 func main() {
-	dbPath := env("APP_DB_PATH", "/data/puipr.db")
-	addr := env("APP_ADDR", "0.0.0.0:1707")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	if envFile := os.Getenv("APP_ENV_FILE"); envFile != "" {
+		if err := loadDotEnv(envFile); err != nil {
+			log.Fatalf("load APP_ENV_FILE: %v", err)
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	mustFileDir(dbPath)
+	driver := cfg.DBDriver
+	dsn := cfg.DBDSN
+	if driver == "postgres" {
+		if dsn == "" {
+			log.Fatal("APP_DB_DSN is required when APP_DB_DRIVER=postgres")
+		}
+	} else {
+		mustFileDir(cfg.DBPath)
+		dsn = cfg.DBPath
+	}
 
-	// SQLite DSN met WAL + busy_timeout
-	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", dbPath)
-	db, err := sql.Open("sqlite", dsn)
+	store, err := openStore(driver, dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
+	db := store.DB()
 	defer db.Close()
-	db.SetMaxOpenConns(1) // SQLite: single-writer
+
+	if driver == "postgres" && cfg.DBMaxOpenConns != nil {
+		db.SetMaxOpenConns(*cfg.DBMaxOpenConns)
+	}
+	if cfg.DBMaxIdleConns != nil {
+		db.SetMaxIdleConns(*cfg.DBMaxIdleConns)
+	}
 
 	if err := pingRetry(db, 10, 300*time.Millisecond); err != nil {
 		log.Fatalf("db ping failed: %v", err)
 	}
-	if err := ensureSchema(db); err != nil {
+	if err := store.EnsureSchema(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 
+	metrics := newMetrics(store)
+
 	// Custom tijdformatter: dd/mm/yyyy hh:mm
 	formatTime := func(ts string) string {
 		if ts == "" {
@@ -93,47 +129,114 @@ func main() {
 	}
 
 	// Template met formatterfunctie
-	tpl := template.Must(template.New("").Funcs(template.FuncMap{"formatTime": formatTime}).ParseFS(tplFS, "templates/*.html"))
+	tpl := template.Must(template.New("").Funcs(template.FuncMap{
+		"formatTime":  formatTime,
+		"countryFlag": countryFlagEmoji,
+	}).ParseFS(tplFS, "templates/*.html"))
 
-	// Fetcher config uit env
-	tURL := env("TAUTULLI_URL", "")
-	tKey := env("TAUTULLI_APIKEY", "")
-	lenStr := env("TAUTULLI_LENGTH", "100")
-	intervalStr := env("FETCH_INTERVAL", "5m")
-	tLen, _ := strconv.Atoi(lenStr)
-	if tLen <= 0 {
-		tLen = 100
+	sources, err := loadTautulliSources(cfg)
+	if err != nil {
+		log.Fatal(err)
 	}
-	itv, err := time.ParseDuration(intervalStr)
-	if err != nil || itv < time.Second {
-		itv = 5 * time.Minute
+
+	var geoip *geoIPReader
+	if cfg.GeoIPDBPath != "" {
+		geoip, err = newGeoIPReader(cfg.GeoIPDBPath, cfg.GeoIPASNDBPath, cfg.GeoIPCacheSize)
+		if err != nil {
+			log.Fatalf("open geoip db: %v", err)
+		}
+		defer geoip.close()
+		if cfg.GeoIPASNDBPath != "" {
+			log.Printf("GeoIP enrichment enabled (%s, ASN: %s)", cfg.GeoIPDBPath, cfg.GeoIPASNDBPath)
+		} else {
+			log.Printf("GeoIP enrichment enabled (%s, no ASN database configured)", cfg.GeoIPDBPath)
+		}
 	}
 
 	s := &Server{
-		db:             db,
-		tpl:            tpl,
-		tautulliURL:    tURL,
-		tautulliAPIKey: tKey,
-		tautulliLength: tLen,
-		fetchInterval:  itv,
-	}
-
-	// Start fetcher goroutine als geconfigureerd
-	if s.tautulliURL != "" && s.tautulliAPIKey != "" {
-		go s.runFetcher()
-		log.Printf("Fetcher enabled: %s every %s (length=%d)", s.tautulliURL, s.fetchInterval, s.tautulliLength)
+		store:       store,
+		tpl:         tpl,
+		metrics:     metrics,
+		sources:     sources,
+		geoip:       geoip,
+		ingestToken: cfg.IngestToken,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	// Start een fetcher goroutine per geconfigureerde bron
+	if len(s.sources) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runFetchers(ctx)
+		}()
+		for _, src := range s.sources {
+			log.Printf("Fetcher enabled: %s (%s) every %s (length=%d)", src.Name, src.URL, src.Interval, src.Length)
+		}
 	} else {
-		log.Printf("Fetcher disabled (set TAUTULLI_URL and TAUTULLI_APIKEY to enable)")
+		log.Printf("Fetcher disabled (no Tautulli sources configured)")
+	}
+
+	if s.geoip != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runGeoIPReenrichment(ctx)
+		}()
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleHome)
 	mux.HandleFunc("/partial/summary", s.handleSummary)
 	mux.HandleFunc("/partial/user/", s.handleUserIPs) // /partial/user/{id}
-	mux.HandleFunc("/ingest", s.handleIngest)         // POST (array or {data:[...]})
+	ingestLimiter := newIngestRateLimiter(
+		cfg.IngestRatePerMin,
+		cfg.IngestBurst,
+		cfg.IngestRateLRUSize,
+		cfg.IngestMaxConcurrency,
+	)
+	mux.Handle("/ingest", ingestLimiter.middleware(http.HandlerFunc(s.handleIngest))) // POST (array or {data:[...]})
+
+	if cfg.AdminToken != "" {
+		mux.Handle("/metrics", adminAuth(cfg.AdminToken, s.metrics.handler()))
+		log.Printf("Metrics enabled on /metrics (admin token required)")
+	}
+
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler := realIPMiddleware(trustedProxies, cfg.ProxyHeader, logRequest(mux))
+
+	httpSrv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Printf("shutting down (signal received)")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("http shutdown: %v", err)
+		}
+	}()
 
-	log.Printf("PUIPR (SQLite) listening on %s (db: %s)", addr, dbPath)
-	log.Fatal(http.ListenAndServe(addr, logRequest(mux)))
+	log.Printf("PUIPR listening on %s (driver: %s, dsn: %s)", cfg.Addr, driver, dsn)
+	if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	wg.Wait() // wait for the fetcher to notice ctx.Done() and exit cleanly
+	log.Printf("shutdown complete")
 }
 
 func logRequest(next http.Handler) http.Handler {
@@ -141,7 +244,11 @@ func logRequest(next http.Handler) http.Handler {
 		start := time.Now()
 		next.ServeHTTP(w, r)
 		loc, _ := time.LoadLocation("Europe/Amsterdam")
-		log.Printf("[%s] %s %s (%v)", time.Now().In(loc).Format("02/01/2006 15:04"), r.Method, r.URL.Path, time.Since(start))
+		ip := realIPFromContext(r.Context())
+		if ip == "" {
+			ip = r.RemoteAddr
+		}
+		log.Printf("[%s] %s %s %s (%v)", time.Now().In(loc).Format("02/01/2006 15:04"), ip, r.Method, r.URL.Path, time.Since(start))
 	})
 }
 
@@ -159,54 +266,6 @@ func pingRetry(db *sql.DB, tries int, backoff time.Duration) error {
 	return err
 }
 
-func ensureSchema(db *sql.DB) error {
-	stmt := `
-CREATE TABLE IF NOT EXISTS plex_users (
-  id            INTEGER PRIMARY KEY,         -- user_id
-  username      TEXT NOT NULL,
-  friendly_name TEXT,
-  user_thumb    TEXT,
-  last_seen     TEXT NOT NULL                -- RFC3339
-);
-
-CREATE TABLE IF NOT EXISTS user_ip_history (
-  id         INTEGER PRIMARY KEY AUTOINCREMENT,
-  user_id    INTEGER NOT NULL,
-  ip         TEXT NOT NULL,
-  first_seen TEXT NOT NULL,
-  last_seen  TEXT NOT NULL,
-  CONSTRAINT uq_user_ip UNIQUE (user_id, ip),
-  FOREIGN KEY(user_id) REFERENCES plex_users(id) ON DELETE CASCADE
-);
-
-CREATE INDEX IF NOT EXISTS idx_user_ip_history_user_last
-ON user_ip_history (user_id, last_seen DESC);
-
-CREATE VIEW IF NOT EXISTS users_last_ip AS
-SELECT
-  pu.id AS user_id,
-  pu.username,
-  pu.friendly_name,
-  (
-    SELECT uih.ip
-    FROM user_ip_history uih
-    WHERE uih.user_id = pu.id
-    ORDER BY uih.last_seen DESC
-    LIMIT 1
-  ) AS last_ip,
-  (
-    SELECT uih.last_seen
-    FROM user_ip_history uih
-    WHERE uih.user_id = pu.id
-    ORDER BY uih.last_seen DESC
-    LIMIT 1
-  ) AS updated_at
-FROM plex_users pu;
-`
-	_, err := db.Exec(stmt)
-	return err
-}
-
 func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
@@ -217,44 +276,15 @@ func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
 	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	country := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("country")))
 
-	sqlq := `
-SELECT pu.id, pu.username, pu.friendly_name, uli.last_ip, uli.updated_at
-FROM users_last_ip uli
-JOIN plex_users pu ON pu.id = uli.user_id
-`
-	args := []any{}
-	if q != "" {
-		sqlq += "WHERE pu.username LIKE ? OR IFNULL(uli.last_ip,'') LIKE ? OR IFNULL(pu.friendly_name,'') LIKE ?\n"
-		p := "%" + q + "%"
-		args = append(args, p, p, p)
-	}
-	sqlq += "ORDER BY pu.username ASC"
-
-	type Row struct {
-		UserID       int64
-		Username     string
-		FriendlyName *string
-		LastIP       *string
-		UpdatedAt    *string
-	}
-
-	rows, err := s.db.Query(sqlq, args...)
+	start := time.Now()
+	list, err := s.store.ListSummary(r.Context(), q, country)
+	s.metrics.dbQueryDuration.WithLabelValues("summary").Observe(time.Since(start).Seconds())
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	defer rows.Close()
-
-	var list []Row
-	for rows.Next() {
-		var r Row
-		if err := rows.Scan(&r.UserID, &r.Username, &r.FriendlyName, &r.LastIP, &r.UpdatedAt); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		list = append(list, r)
-	}
 	_ = s.tpl.ExecuteTemplate(w, "summary.html", map[string]any{"Rows": list})
 }
 
@@ -266,38 +296,18 @@ func (s *Server) handleUserIPs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var username string
-	_ = s.db.QueryRow(`SELECT username FROM plex_users WHERE id = ?`, uid).Scan(&username)
+	username, _ := s.store.Username(r.Context(), uid)
 	if username == "" {
 		username = fmt.Sprintf("User %d", uid)
 	}
 
-	type IPRow struct {
-		IP        string
-		FirstSeen string
-		LastSeen  string
-	}
-	rows, err := s.db.Query(`
-SELECT ip, first_seen, last_seen
-FROM user_ip_history
-WHERE user_id = ?
-ORDER BY last_seen DESC
-`, uid)
+	start := time.Now()
+	list, err := s.store.ListUserIPs(r.Context(), uid)
+	s.metrics.dbQueryDuration.WithLabelValues("user_ips").Observe(time.Since(start).Seconds())
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	defer rows.Close()
-
-	var list []IPRow
-	for rows.Next() {
-		var x IPRow
-		if err := rows.Scan(&x.IP, &x.FirstSeen, &x.LastSeen); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		list = append(list, x)
-	}
 	_ = s.tpl.ExecuteTemplate(w, "user_ips.html", map[string]any{"Username": username, "IPs": list})
 }
 
@@ -307,9 +317,8 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Optionele bearer token
-	want := os.Getenv("INGEST_TOKEN")
 	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	if want != "" && got != want {
+	if s.ingestToken != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.ingestToken)) != 1 {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -332,6 +341,20 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 		}
 		arr = obj.Data
 	}
+
+	realIP := realIPFromContext(r.Context())
+	for i := range arr {
+		if arr[i].Source == "" {
+			arr[i].Source = "ingest"
+		}
+		// Direct scrobble-style pushes often omit ip_address; fall back to
+		// the (proxy-resolved) caller IP so user_ip_history still gets populated.
+		if realIP != "" && (arr[i].IPAddress == nil || *arr[i].IPAddress == "") {
+			ip := realIP
+			arr[i].IPAddress = &ip
+		}
+	}
+
 	if len(arr) == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"ingested":0}`))
@@ -347,89 +370,129 @@ func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) ingestItems(ctx context.Context, arr []TautulliItem) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	for _, it := range arr {
-		ts := time.Now().UTC()
-		if it.Date != nil && *it.Date > 0 {
-			ts = time.Unix(*it.Date, 0).UTC()
-		}
-		tsStr := ts.Format(time.RFC3339)
-
-		// Upsert user
-		if _, err := tx.Exec(`
-INSERT INTO plex_users (id, username, friendly_name, user_thumb, last_seen)
-VALUES (?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
-  username=excluded.username,
-  friendly_name=excluded.friendly_name,
-  user_thumb=excluded.user_thumb,
-  last_seen=CASE WHEN plex_users.last_seen > excluded.last_seen THEN plex_users.last_seen ELSE excluded.last_seen END
-`, it.UserID, it.User, it.FriendlyName, it.UserThumb, tsStr); err != nil {
-			return fmt.Errorf("user upsert: %w", err)
-		}
-
-		// Upsert IP
-		if it.IPAddress != nil && *it.IPAddress != "" {
-			if _, err := tx.Exec(`
-INSERT INTO user_ip_history (user_id, ip, first_seen, last_seen)
-VALUES (?, ?, ?, ?)
-ON CONFLICT(user_id, ip) DO UPDATE SET
-  last_seen=CASE WHEN user_ip_history.last_seen > excluded.last_seen THEN user_ip_history.last_seen ELSE excluded.last_seen END
-`, it.UserID, *it.IPAddress, tsStr, tsStr); err != nil {
-				return fmt.Errorf("ip upsert: %w", err)
+	if s.geoip != nil {
+		for i := range arr {
+			if arr[i].IPAddress == nil || *arr[i].IPAddress == "" {
+				continue
 			}
+			geo := s.geoip.lookup(*arr[i].IPAddress)
+			arr[i].Country, arr[i].City, arr[i].ASN = geo.Country, geo.City, geo.ASN
 		}
 	}
-
-	return tx.Commit()
+	if err := s.store.IngestBatch(ctx, arr); err != nil {
+		s.metrics.ingestErrorsTotal.Inc()
+		return err
+	}
+	s.metrics.ingestItemsTotal.Add(float64(len(arr)))
+	return nil
 }
 
 // ---------------- Fetcher (in-app) ----------------
 
-func (s *Server) runFetcher() {
+// runFetchers spawns one goroutine per configured Tautulli source, each
+// with its own ticker and HTTP client, and blocks until they've all
+// returned (which happens when ctx is cancelled).
+func (s *Server) runFetchers(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, src := range s.sources {
+		wg.Add(1)
+		go func(src tautulliSource) {
+			defer wg.Done()
+			s.runFetcher(ctx, src)
+		}(src)
+	}
+	wg.Wait()
+}
+
+func (s *Server) runFetcher(ctx context.Context, src tautulliSource) {
 	client := &http.Client{Timeout: 15 * time.Second}
 
 	// run immediately, then on ticker
-	s.fetchOnce(client)
-	t := time.NewTicker(s.fetchInterval)
+	s.fetchOnce(client, src)
+	t := time.NewTicker(src.Interval)
 	defer t.Stop()
-	for range t.C {
-		s.fetchOnce(client)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("fetcher %s stopping: %v", src.Name, ctx.Err())
+			return
+		case <-t.C:
+			s.fetchOnce(client, src)
+		}
 	}
 }
 
-func (s *Server) fetchOnce(client *http.Client) {
-	items, err := s.fetchTautulli(client)
+func (s *Server) fetchOnce(client *http.Client, src tautulliSource) {
+	start := time.Now()
+	items, err := s.fetchTautulli(client, src)
+	s.metrics.tautulliFetchDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
-		log.Printf("fetch error: %v", err)
+		s.metrics.tautulliFetchErrorsTotal.Inc()
+		log.Printf("fetch error (%s): %v", src.Name, err)
 		return
 	}
 	if len(items) == 0 {
 		return
 	}
+	for i := range items {
+		items[i].Source = src.Name
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 	if err := s.ingestItems(ctx, items); err != nil {
-		log.Printf("ingest error: %v", err)
+		log.Printf("ingest error (%s): %v", src.Name, err)
+		return
+	}
+	log.Printf("ingested %d items from Tautulli source %s: %s", len(items), src.Name, src.URL)
+}
+
+// ---------------- GeoIP re-enrichment ----------------
+
+// runGeoIPReenrichment periodically fills in country/city/ASN for
+// user_ip_history rows that predate GeoIP being enabled (or whose lookup
+// failed at ingest time), until ctx is cancelled.
+func (s *Server) runGeoIPReenrichment(ctx context.Context) {
+	t := time.NewTicker(24 * time.Hour)
+	defer t.Stop()
+	for {
+		s.reenrichGeoIPOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Server) reenrichGeoIPOnce(ctx context.Context) {
+	targets, err := s.store.ListIPsMissingGeo(ctx, 1000)
+	if err != nil {
+		log.Printf("geoip re-enrichment: list rows: %v", err)
 		return
 	}
-	log.Printf("ingested %d items from Tautulli: %s", len(items), s.tautulliURL)
+	for _, target := range targets {
+		geo := s.geoip.lookup(target.IP)
+		if geo.Country == "" {
+			continue
+		}
+		if err := s.store.UpdateIPGeo(ctx, target.ID, geo.Country, geo.City, geo.ASN); err != nil {
+			log.Printf("geoip re-enrichment: update row %d: %v", target.ID, err)
+		}
+	}
+	if len(targets) > 0 {
+		log.Printf("geoip re-enrichment: processed %d rows", len(targets))
+	}
 }
 
-func (s *Server) fetchTautulli(client *http.Client) ([]TautulliItem, error) {
-	u, err := url.Parse(s.tautulliURL)
+func (s *Server) fetchTautulli(client *http.Client, src tautulliSource) ([]TautulliItem, error) {
+	u, err := url.Parse(src.URL)
 	if err != nil {
 		return nil, err
 	}
 	q := u.Query()
-	q.Set("apikey", s.tautulliAPIKey)
+	q.Set("apikey", src.APIKey)
 	q.Set("cmd", "get_history")
-	q.Set("length", strconv.Itoa(s.tautulliLength))
+	q.Set("length", strconv.Itoa(src.Length))
 	q.Set("order_column", "date")
 	q.Set("order_dir", "desc")
 	u.RawQuery = q.Encode()
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics bundles the Prometheus collectors PUIPR exposes on /metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	ingestItemsTotal  prometheus.Counter
+	ingestErrorsTotal prometheus.Counter
+
+	tautulliFetchDuration    prometheus.Histogram
+	tautulliFetchErrorsTotal prometheus.Counter
+
+	dbQueryDuration *prometheus.SummaryVec
+}
+
+// newMetrics builds and registers all PUIPR collectors on a fresh registry.
+// The users/ip-history gauges are GaugeFuncs: they run a cheap COUNT(*)
+// lazily on every scrape rather than being kept up to date continuously.
+func newMetrics(store Store) *Metrics {
+	reg := prometheus.NewRegistry()
+
+	m := &Metrics{
+		registry: reg,
+		ingestItemsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "puipr_ingest_items_total",
+			Help: "Total number of items successfully ingested.",
+		}),
+		ingestErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "puipr_ingest_errors_total",
+			Help: "Total number of ingest batches that failed.",
+		}),
+		tautulliFetchDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "puipr_tautulli_fetch_duration_seconds",
+			Help: "Duration of Tautulli get_history fetches.",
+		}),
+		tautulliFetchErrorsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "puipr_tautulli_fetch_errors_total",
+			Help: "Total number of Tautulli fetches that failed.",
+		}),
+		dbQueryDuration: prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name:       "puipr_db_query_duration_seconds",
+			Help:       "Duration of the summary/user-ips database queries.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}, []string{"handler"}),
+	}
+
+	reg.MustRegister(
+		m.ingestItemsTotal,
+		m.ingestErrorsTotal,
+		m.tautulliFetchDuration,
+		m.tautulliFetchErrorsTotal,
+		m.dbQueryDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "puipr_users_total",
+			Help: "Rows in plex_users, counted on scrape.",
+		}, func() float64 {
+			n, _ := store.CountUsers(context.Background())
+			return float64(n)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "puipr_ip_history_rows_total",
+			Help: "Rows in user_ip_history, counted on scrape.",
+		}, func() float64 {
+			n, _ := store.CountIPHistoryRows(context.Background())
+			return float64(n)
+		}),
+	)
+
+	return m
+}
+
+func (m *Metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// adminAuth gates next behind a static bearer token (chihaya-style admin token).
+func adminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
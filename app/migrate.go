@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// runMigrate copies all plex_users and user_ip_history rows from a SQLite
+// database into a Postgres database, for operators moving off the
+// single-writer default. Invoked as: puipr migrate <sqlite-path> <postgres-dsn>
+func runMigrate(args []string) {
+	if len(args) != 2 {
+		log.Fatal("usage: puipr migrate <sqlite-path> <postgres-dsn>")
+	}
+	sqlitePath, pgDSN := args[0], args[1]
+
+	src, err := newSQLiteStore(sqlitePath)
+	if err != nil {
+		log.Fatalf("open sqlite source: %v", err)
+	}
+	defer src.DB().Close()
+
+	dst, err := newPostgresStore(pgDSN)
+	if err != nil {
+		log.Fatalf("open postgres destination: %v", err)
+	}
+	defer dst.DB().Close()
+
+	ctx := context.Background()
+	if err := dst.EnsureSchema(ctx); err != nil {
+		log.Fatalf("ensure postgres schema: %v", err)
+	}
+
+	nUsers, err := migrateUsers(ctx, src, dst)
+	if err != nil {
+		log.Fatalf("migrate plex_users: %v", err)
+	}
+	nIPs, err := migrateIPHistory(ctx, src, dst)
+	if err != nil {
+		log.Fatalf("migrate user_ip_history: %v", err)
+	}
+
+	fmt.Printf("migrated %d users and %d IP history rows to postgres\n", nUsers, nIPs)
+}
+
+func migrateUsers(ctx context.Context, src *SQLiteStore, dst *PostgresStore) (int, error) {
+	rows, err := src.DB().QueryContext(ctx, `SELECT id, username, friendly_name, user_thumb, last_seen, last_source FROM plex_users`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var id int64
+		var username, lastSeen string
+		var friendlyName, userThumb, lastSource *string
+		if err := rows.Scan(&id, &username, &friendlyName, &userThumb, &lastSeen, &lastSource); err != nil {
+			return n, err
+		}
+		if _, err := dst.DB().ExecContext(ctx, `
+INSERT INTO plex_users (id, username, friendly_name, user_thumb, last_seen, last_source)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT(id) DO UPDATE SET
+  username=excluded.username,
+  friendly_name=excluded.friendly_name,
+  user_thumb=excluded.user_thumb,
+  last_seen=excluded.last_seen,
+  last_source=excluded.last_source
+`, id, username, friendlyName, userThumb, lastSeen, lastSource); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func migrateIPHistory(ctx context.Context, src *SQLiteStore, dst *PostgresStore) (int, error) {
+	rows, err := src.DB().QueryContext(ctx, `SELECT user_id, ip, first_seen, last_seen, source, country, city, asn FROM user_ip_history`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var userID int64
+		var ip, firstSeen, lastSeen, source string
+		var country, city, asn *string
+		if err := rows.Scan(&userID, &ip, &firstSeen, &lastSeen, &source, &country, &city, &asn); err != nil {
+			return n, err
+		}
+		if _, err := dst.DB().ExecContext(ctx, `
+INSERT INTO user_ip_history (user_id, ip, first_seen, last_seen, source, country, city, asn)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT(user_id, ip) DO UPDATE SET
+  first_seen=excluded.first_seen,
+  last_seen=excluded.last_seen,
+  source=excluded.source,
+  country=excluded.country,
+  city=excluded.city,
+  asn=excluded.asn
+`, userID, ip, firstSeen, lastSeen, source, country, city, asn); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
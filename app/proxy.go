@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type ctxKey int
+
+const realIPCtxKey ctxKey = iota
+
+// parseTrustedProxies parses a comma-separated CIDR list (IPv4 and IPv6),
+// e.g. "10.0.0.0/8,2001:db8::/32". An empty string yields no trusted proxies.
+func parseTrustedProxies(csv string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(csv, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("bad APP_TRUSTED_PROXIES entry %q: %w", part, err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// realIPMiddleware resolves the caller's real IP and stashes it on the
+// request context for downstream handlers and logRequest. When the direct
+// peer (r.RemoteAddr) is a trusted proxy, the real IP is the rightmost
+// untrusted address in header (scanning from the server end, skipping
+// trusted hops); otherwise callers are ignored to prevent spoofing and the
+// direct peer address is used as-is.
+func realIPMiddleware(trusted []*net.IPNet, header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveRealIP(r, trusted, header)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), realIPCtxKey, ip)))
+	})
+}
+
+func resolveRealIP(r *http.Request, trusted []*net.IPNet, header string) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || len(trusted) == 0 || !isTrusted(peer, trusted) {
+		return host
+	}
+
+	hv := r.Header.Get(header)
+	if hv == "" {
+		return host
+	}
+	// A trusted proxy only ever appends the peer it directly saw, so the
+	// entries closest to the server are the ones we can trust; walk from
+	// the right and skip those until we hit the first untrusted hop, which
+	// is the real caller. Scanning left-to-right would let a client simply
+	// prepend a fake address to the header it sends.
+	parts := strings.Split(hv, ",")
+	for i := len(parts) - 1; i >= 0; i-- {
+		cand := strings.TrimSpace(parts[i])
+		ip := net.ParseIP(cand)
+		if ip == nil {
+			continue
+		}
+		if !isTrusted(ip, trusted) {
+			return cand
+		}
+	}
+	return host
+}
+
+// realIPFromContext returns the IP resolved by realIPMiddleware, or "" if
+// the middleware was never applied.
+func realIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(realIPCtxKey).(string)
+	return ip
+}
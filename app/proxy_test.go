@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResolveRealIPUntrustedPeerIgnoresHeader(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{
+		RemoteAddr: "203.0.113.9:5555",
+		Header:     http.Header{"X-Forwarded-For": []string{"1.2.3.4"}},
+	}
+	if got := resolveRealIP(r, trusted, "X-Forwarded-For"); got != "203.0.113.9" {
+		t.Errorf("resolveRealIP = %q, want direct peer (spoofed header must be ignored)", got)
+	}
+}
+
+func TestResolveRealIPTrustedPeerUsesRightmostUntrustedHeaderIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{
+		RemoteAddr: "10.0.0.1:5555",
+		Header:     http.Header{"X-Forwarded-For": []string{"1.2.3.4, 10.0.0.2"}},
+	}
+	if got := resolveRealIP(r, trusted, "X-Forwarded-For"); got != "1.2.3.4" {
+		t.Errorf("resolveRealIP = %q, want 1.2.3.4", got)
+	}
+}
+
+// TestResolveRealIPIgnoresClientSpoofedLeadingEntry guards against scanning
+// the header left-to-right: a client can prepend any fake address to the
+// X-Forwarded-For it sends, so the entry closest to the trusted proxy (the
+// rightmost untrusted one) must win, not the leftmost.
+func TestResolveRealIPIgnoresClientSpoofedLeadingEntry(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{
+		RemoteAddr: "10.0.0.1:5555",
+		Header:     http.Header{"X-Forwarded-For": []string{"8.8.8.8, 203.0.113.50"}},
+	}
+	if got := resolveRealIP(r, trusted, "X-Forwarded-For"); got != "203.0.113.50" {
+		t.Errorf("resolveRealIP = %q, want real caller 203.0.113.50 (not the spoofed 8.8.8.8)", got)
+	}
+}
+
+func TestResolveRealIPTrustedPeerAllHeaderIPsTrustedFallsBackToPeer(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{
+		RemoteAddr: "10.0.0.1:5555",
+		Header:     http.Header{"X-Forwarded-For": []string{"10.0.0.2, 10.0.0.3"}},
+	}
+	if got := resolveRealIP(r, trusted, "X-Forwarded-For"); got != "10.0.0.1" {
+		t.Errorf("resolveRealIP = %q, want direct peer 10.0.0.1", got)
+	}
+}
+
+func TestResolveRealIPNoTrustedProxiesConfiguredUsesPeer(t *testing.T) {
+	r := &http.Request{
+		RemoteAddr: "10.0.0.1:5555",
+		Header:     http.Header{"X-Forwarded-For": []string{"1.2.3.4"}},
+	}
+	if got := resolveRealIP(r, nil, "X-Forwarded-For"); got != "10.0.0.1" {
+		t.Errorf("resolveRealIP = %q, want direct peer 10.0.0.1", got)
+	}
+}
+
+func TestParseTrustedProxiesRejectsBadCIDR(t *testing.T) {
+	if _, err := parseTrustedProxies("not-a-cidr"); err == nil {
+		t.Error("expected an error for an invalid CIDR entry")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a classic token-bucket limiter: it refills at ratePerSec
+// and holds at most burst tokens (the discosrv pattern).
+type tokenBucket struct {
+	mu         sync.Mutex
+	tokens     float64
+	ratePerSec float64
+	burst      float64
+	last       time.Time
+}
+
+func newTokenBucket(ratePerSec, burst float64) *tokenBucket {
+	return &tokenBucket{tokens: burst, ratePerSec: ratePerSec, burst: burst, last: time.Now()}
+}
+
+// allow reports whether a request may proceed now. When it returns false,
+// retryAfter is the duration until a token is next available.
+func (b *tokenBucket) allow() (ok bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.ratePerSec
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	need := 1 - b.tokens
+	return false, time.Duration(need/b.ratePerSec*float64(time.Second)) + time.Millisecond
+}
+
+// bucketLRU is a size-bounded, concurrent-safe LRU of per-key token buckets.
+// Bounding it keeps a flood of unique source IPs from growing memory
+// without limit; idleTTL additionally expires buckets nobody has hit in a
+// while. It's a thin wrapper over the generic lru shared with geoLRU.
+type bucketLRU struct {
+	lru *lru[*tokenBucket]
+}
+
+func newBucketLRU(capacity int, idleTTL time.Duration) *bucketLRU {
+	return &bucketLRU{lru: newLRU[*tokenBucket](capacity, idleTTL)}
+}
+
+func (l *bucketLRU) get(key string, ratePerSec, burst float64) *tokenBucket {
+	return l.lru.getOrCreate(key, func() *tokenBucket { return newTokenBucket(ratePerSec, burst) })
+}
+
+// ingestRateLimiter rate-limits /ingest per caller IP with a token bucket,
+// and caps global concurrency with a buffered semaphore so a burst of
+// ingests cannot starve the SQLite single-writer.
+type ingestRateLimiter struct {
+	buckets    *bucketLRU
+	ratePerSec float64
+	burst      float64
+	sem        chan struct{}
+}
+
+func newIngestRateLimiter(ratePerMin, burst float64, lruSize, maxConcurrency int) *ingestRateLimiter {
+	return &ingestRateLimiter{
+		buckets:    newBucketLRU(lruSize, 10*time.Minute),
+		ratePerSec: ratePerMin / 60,
+		burst:      burst,
+		sem:        make(chan struct{}, maxConcurrency),
+	}
+}
+
+func (l *ingestRateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := realIPFromContext(r.Context())
+		if key == "" {
+			key = r.RemoteAddr
+		}
+
+		b := l.buckets.get(key, l.ratePerSec, l.burst)
+		if ok, retryAfter := b.allow(); !ok {
+			secs := int(retryAfter/time.Second) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		select {
+		case l.sem <- struct{}{}:
+			defer func() { <-l.sem }()
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server busy", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
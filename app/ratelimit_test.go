@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowsBurstThenLimits(t *testing.T) {
+	b := newTokenBucket(1, 3) // 1/sec, burst of 3
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.allow(); !ok {
+			t.Fatalf("request %d: expected burst capacity to allow it", i)
+		}
+	}
+	ok, retryAfter := b.allow()
+	if ok {
+		t.Fatal("expected burst to be exhausted")
+	}
+	// allow() adds a fixed 1ms safety margin on top of the computed wait, so
+	// the bound needs a little headroom past the nominal 1s.
+	if retryAfter <= 0 || retryAfter > time.Second+10*time.Millisecond {
+		t.Errorf("retryAfter = %v, want roughly up to 1s (plus allow()'s 1ms safety margin)", retryAfter)
+	}
+}
+
+func TestBucketLRUEvictsOldestOverCapacity(t *testing.T) {
+	l := newBucketLRU(2, time.Hour)
+
+	a := l.get("a", 1, 1)
+	l.get("b", 1, 1)
+	l.get("c", 1, 1) // should evict "a", the least recently used
+
+	if got := l.get("a", 1, 1); got == a {
+		t.Error("expected a fresh bucket for \"a\": it should have been evicted")
+	}
+}
+
+func TestBucketLRUEvictsIdleEntries(t *testing.T) {
+	l := newBucketLRU(10, time.Millisecond)
+
+	first := l.get("a", 1, 1)
+	time.Sleep(5 * time.Millisecond)
+	l.get("b", 1, 1) // triggers idle eviction of "a" on insert
+
+	if got := l.get("a", 1, 1); got == first {
+		t.Error("expected \"a\" to have been evicted for being idle past idleTTL")
+	}
+}
+
+func TestIngestRateLimiterMiddlewareReturnsRetryAfterWhenExhausted(t *testing.T) {
+	limiter := newIngestRateLimiter(60, 1, 16, 4) // 1/sec, burst 1
+	handler := limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+
+	rec1 := httptest.NewRecorder()
+	handler.ServeHTTP(rec1, req)
+	if rec1.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d, want 200", rec1.Code)
+	}
+
+	rec2 := httptest.NewRecorder()
+	handler.ServeHTTP(rec2, req)
+	if rec2.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status = %d, want 429", rec2.Code)
+	}
+	if rec2.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header on the 429")
+	}
+}
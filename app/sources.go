@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// tautulliSource is one configured Tautulli endpoint to poll.
+type tautulliSource struct {
+	Name     string
+	URL      string
+	APIKey   string
+	Length   int
+	Interval time.Duration
+}
+
+// tautulliSourceFile is the shape of one entry in the config file's
+// "sources" list (see config.go); Interval is a duration string there
+// since JSON has no duration type.
+type tautulliSourceFile struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	APIKey   string `json:"api_key"`
+	Length   int    `json:"length"`
+	Interval string `json:"interval"`
+}
+
+// loadTautulliSources builds the list of Tautulli sources to poll. As with
+// every other setting, env vars win over the config file: numbered env var
+// groups (TAUTULLI_URL_1, TAUTULLI_APIKEY_1, TAUTULLI_LENGTH_1,
+// FETCH_INTERVAL_1, ...) if any are set, else the legacy single-source env
+// vars (TAUTULLI_URL, ...) so existing deployments keep working untouched,
+// else the "sources" section of the structured config file (see config.go).
+func loadTautulliSources(cfg *Config) ([]tautulliSource, error) {
+	if sources := loadTautulliSourcesFromEnv(); len(sources) > 0 {
+		return sources, nil
+	}
+	if sources := loadLegacyTautulliSource(); len(sources) > 0 {
+		return sources, nil
+	}
+	return buildTautulliSources(cfg.Sources), nil
+}
+
+// buildTautulliSources converts the raw config-file shape into the
+// tautulliSource values runFetchers consumes, applying the same defaults
+// as the env-var and legacy loading paths below.
+func buildTautulliSources(raw []tautulliSourceFile) []tautulliSource {
+	sources := make([]tautulliSource, 0, len(raw))
+	for _, r := range raw {
+		itv, err := time.ParseDuration(r.Interval)
+		if err != nil || itv < time.Second {
+			itv = 5 * time.Minute
+		}
+		length := r.Length
+		if length <= 0 {
+			length = 100
+		}
+		sources = append(sources, tautulliSource{Name: r.Name, URL: r.URL, APIKey: r.APIKey, Length: length, Interval: itv})
+	}
+	return sources
+}
+
+func loadTautulliSourcesFromEnv() []tautulliSource {
+	var sources []tautulliSource
+	for i := 1; ; i++ {
+		suffix := "_" + strconv.Itoa(i)
+		u := os.Getenv("TAUTULLI_URL" + suffix)
+		if u == "" {
+			break
+		}
+		apiKey := os.Getenv("TAUTULLI_APIKEY" + suffix)
+		if apiKey == "" {
+			continue // URL without a key: same as the baseline, don't start a fetcher doomed to fail every tick
+		}
+		length, _ := strconv.Atoi(env("TAUTULLI_LENGTH"+suffix, "100"))
+		if length <= 0 {
+			length = 100
+		}
+		itv, err := time.ParseDuration(env("FETCH_INTERVAL"+suffix, "5m"))
+		if err != nil || itv < time.Second {
+			itv = 5 * time.Minute
+		}
+		sources = append(sources, tautulliSource{
+			Name:     env("TAUTULLI_NAME"+suffix, fmt.Sprintf("source-%d", i)),
+			URL:      u,
+			APIKey:   apiKey,
+			Length:   length,
+			Interval: itv,
+		})
+	}
+	return sources
+}
+
+func loadLegacyTautulliSource() []tautulliSource {
+	u := env("TAUTULLI_URL", "")
+	apiKey := env("TAUTULLI_APIKEY", "")
+	if u == "" || apiKey == "" {
+		return nil
+	}
+	length, _ := strconv.Atoi(env("TAUTULLI_LENGTH", "100"))
+	if length <= 0 {
+		length = 100
+	}
+	itv, err := time.ParseDuration(env("FETCH_INTERVAL", "5m"))
+	if err != nil || itv < time.Second {
+		itv = 5 * time.Minute
+	}
+	return []tautulliSource{{
+		Name:     "default",
+		URL:      u,
+		APIKey:   apiKey,
+		Length:   length,
+		Interval: itv,
+	}}
+}
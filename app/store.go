@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SummaryRow is one row of the /partial/summary listing.
+type SummaryRow struct {
+	UserID       int64
+	Username     string
+	FriendlyName *string
+	LastIP       *string
+	UpdatedAt    *string
+	LastSource   *string
+	Country      *string
+}
+
+// IPRow is one row of the /partial/user/{id} IP history listing.
+type IPRow struct {
+	IP        string
+	FirstSeen string
+	LastSeen  string
+	Source    string
+	Country   string
+	City      string
+	ASN       string
+}
+
+// IPGeoTarget identifies a user_ip_history row awaiting GeoIP enrichment.
+type IPGeoTarget struct {
+	ID int64
+	IP string
+}
+
+// Store is the persistence backend for plex users and their IP history.
+// PUIPR ships a SQLite implementation (the default, single-writer) and a
+// PostgreSQL implementation for multi-writer deployments, selected by
+// APP_DB_DRIVER.
+type Store interface {
+	DB() *sql.DB
+	EnsureSchema(ctx context.Context) error
+	UpsertUser(ctx context.Context, it TautulliItem, seenAt time.Time, source string) error
+	UpsertIP(ctx context.Context, userID int64, ip string, seenAt time.Time, source string) error
+	// IngestBatch upserts a whole Tautulli batch inside a single transaction.
+	IngestBatch(ctx context.Context, arr []TautulliItem) error
+	ListSummary(ctx context.Context, q, country string) ([]SummaryRow, error)
+	ListUserIPs(ctx context.Context, userID int64) ([]IPRow, error)
+	Username(ctx context.Context, userID int64) (string, error)
+	CountUsers(ctx context.Context) (int64, error)
+	CountIPHistoryRows(ctx context.Context) (int64, error)
+	// ListIPsMissingGeo returns up to limit user_ip_history rows whose
+	// country is still unset, for the nightly GeoIP re-enrichment pass.
+	ListIPsMissingGeo(ctx context.Context, limit int) ([]IPGeoTarget, error)
+	UpdateIPGeo(ctx context.Context, id int64, country, city, asn string) error
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx, letting the upsert
+// helpers below run either standalone or inside a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// seenAtFor resolves the timestamp to record for a TautulliItem, falling
+// back to now when the item carries no date.
+func seenAtFor(it TautulliItem) time.Time {
+	if it.Date != nil && *it.Date > 0 {
+		return time.Unix(*it.Date, 0).UTC()
+	}
+	return time.Now().UTC()
+}
+
+// openStore opens the configured backend. driver is "sqlite" (default) or
+// "postgres"; dsn is the SQLite file path or the Postgres connection string
+// respectively.
+func openStore(driver, dsn string) (Store, error) {
+	switch driver {
+	case "", "sqlite":
+		return newSQLiteStore(dsn)
+	case "postgres":
+		return newPostgresStore(dsn)
+	default:
+		return nil, fmt.Errorf("unknown APP_DB_DRIVER %q (want sqlite or postgres)", driver)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore is the multi-writer Store backend, selected via
+// APP_DB_DRIVER=postgres. The upsert syntax is identical to SQLite's
+// (ON CONFLICT ... DO UPDATE SET ... = excluded.*); only placeholders and
+// a couple of column types differ.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func newPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) DB() *sql.DB { return s.db }
+
+func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS plex_users (
+  id            BIGINT PRIMARY KEY,
+  username      TEXT NOT NULL,
+  friendly_name TEXT,
+  user_thumb    TEXT,
+  last_seen     TEXT NOT NULL,
+  last_source   TEXT
+);
+
+CREATE TABLE IF NOT EXISTS user_ip_history (
+  id         BIGSERIAL PRIMARY KEY,
+  user_id    BIGINT NOT NULL REFERENCES plex_users(id) ON DELETE CASCADE,
+  ip         TEXT NOT NULL,
+  first_seen TEXT NOT NULL,
+  last_seen  TEXT NOT NULL,
+  source     TEXT NOT NULL DEFAULT 'ingest',
+  country    TEXT,
+  city       TEXT,
+  asn        TEXT,
+  CONSTRAINT uq_user_ip UNIQUE (user_id, ip)
+);
+
+ALTER TABLE plex_users ADD COLUMN IF NOT EXISTS last_source TEXT;
+ALTER TABLE user_ip_history ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'ingest';
+ALTER TABLE user_ip_history ADD COLUMN IF NOT EXISTS country TEXT;
+ALTER TABLE user_ip_history ADD COLUMN IF NOT EXISTS city TEXT;
+ALTER TABLE user_ip_history ADD COLUMN IF NOT EXISTS asn TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_user_ip_history_user_last
+ON user_ip_history (user_id, last_seen DESC);
+
+CREATE OR REPLACE VIEW users_last_ip AS
+SELECT
+  pu.id AS user_id,
+  pu.username,
+  pu.friendly_name,
+  pu.last_source,
+  (
+    SELECT uih.ip
+    FROM user_ip_history uih
+    WHERE uih.user_id = pu.id
+    ORDER BY uih.last_seen DESC
+    LIMIT 1
+  ) AS last_ip,
+  (
+    SELECT uih.last_seen
+    FROM user_ip_history uih
+    WHERE uih.user_id = pu.id
+    ORDER BY uih.last_seen DESC
+    LIMIT 1
+  ) AS updated_at,
+  (
+    SELECT uih.country
+    FROM user_ip_history uih
+    WHERE uih.user_id = pu.id
+    ORDER BY uih.last_seen DESC
+    LIMIT 1
+  ) AS country
+FROM plex_users pu;
+`)
+	return err
+}
+
+func (s *PostgresStore) UpsertUser(ctx context.Context, it TautulliItem, seenAt time.Time, source string) error {
+	return postgresUpsertUser(ctx, s.db, it, seenAt, source)
+}
+
+func (s *PostgresStore) UpsertIP(ctx context.Context, userID int64, ip string, seenAt time.Time, source string) error {
+	return postgresUpsertIP(ctx, s.db, userID, ip, seenAt, source, geoInfo{})
+}
+
+func postgresUpsertUser(ctx context.Context, ex execer, it TautulliItem, seenAt time.Time, source string) error {
+	_, err := ex.ExecContext(ctx, `
+INSERT INTO plex_users (id, username, friendly_name, user_thumb, last_seen, last_source)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT(id) DO UPDATE SET
+  username=excluded.username,
+  friendly_name=excluded.friendly_name,
+  user_thumb=excluded.user_thumb,
+  last_seen=CASE WHEN plex_users.last_seen > excluded.last_seen THEN plex_users.last_seen ELSE excluded.last_seen END,
+  last_source=CASE WHEN plex_users.last_seen > excluded.last_seen THEN plex_users.last_source ELSE excluded.last_source END
+`, it.UserID, it.User, it.FriendlyName, it.UserThumb, seenAt.Format(time.RFC3339), source)
+	if err != nil {
+		return fmt.Errorf("user upsert: %w", err)
+	}
+	return nil
+}
+
+func postgresUpsertIP(ctx context.Context, ex execer, userID int64, ip string, seenAt time.Time, source string, geo geoInfo) error {
+	ts := seenAt.Format(time.RFC3339)
+	_, err := ex.ExecContext(ctx, `
+INSERT INTO user_ip_history (user_id, ip, first_seen, last_seen, source, country, city, asn)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT(user_id, ip) DO UPDATE SET
+  last_seen=CASE WHEN user_ip_history.last_seen > excluded.last_seen THEN user_ip_history.last_seen ELSE excluded.last_seen END,
+  source=CASE WHEN user_ip_history.last_seen > excluded.last_seen THEN user_ip_history.source ELSE excluded.source END,
+  country=CASE WHEN excluded.country <> '' THEN excluded.country ELSE user_ip_history.country END,
+  city=CASE WHEN excluded.city <> '' THEN excluded.city ELSE user_ip_history.city END,
+  asn=CASE WHEN excluded.asn <> '' THEN excluded.asn ELSE user_ip_history.asn END
+`, userID, ip, ts, ts, source, geo.Country, geo.City, geo.ASN)
+	if err != nil {
+		return fmt.Errorf("ip upsert: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) IngestBatch(ctx context.Context, arr []TautulliItem) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, it := range arr {
+		ts := seenAtFor(it)
+		source := it.Source
+		if source == "" {
+			source = "ingest"
+		}
+		if err := postgresUpsertUser(ctx, tx, it, ts, source); err != nil {
+			return err
+		}
+		if it.IPAddress != nil && *it.IPAddress != "" {
+			geo := geoInfo{Country: it.Country, City: it.City, ASN: it.ASN}
+			if err := postgresUpsertIP(ctx, tx, it.UserID, *it.IPAddress, ts, source, geo); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) ListSummary(ctx context.Context, q, country string) ([]SummaryRow, error) {
+	sqlq := `
+SELECT pu.id, pu.username, pu.friendly_name, uli.last_ip, uli.updated_at, uli.last_source, uli.country
+FROM users_last_ip uli
+JOIN plex_users pu ON pu.id = uli.user_id
+`
+	var where []string
+	args := []any{}
+	if q != "" {
+		// ILIKE, not LIKE: SQLite's LIKE is ASCII-case-insensitive by default,
+		// so this keeps search behavior identical across backends.
+		where = append(where, fmt.Sprintf("(pu.username ILIKE $%d OR COALESCE(uli.last_ip,'') ILIKE $%d OR COALESCE(pu.friendly_name,'') ILIKE $%d)", len(args)+1, len(args)+2, len(args)+3))
+		p := "%" + q + "%"
+		args = append(args, p, p, p)
+	}
+	if country != "" {
+		where = append(where, fmt.Sprintf("uli.country = $%d", len(args)+1))
+		args = append(args, country)
+	}
+	if len(where) > 0 {
+		sqlq += "WHERE " + strings.Join(where, " AND ") + "\n"
+	}
+	sqlq += "ORDER BY pu.username ASC"
+
+	rows, err := s.db.QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []SummaryRow
+	for rows.Next() {
+		var r SummaryRow
+		if err := rows.Scan(&r.UserID, &r.Username, &r.FriendlyName, &r.LastIP, &r.UpdatedAt, &r.LastSource, &r.Country); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) ListUserIPs(ctx context.Context, userID int64) ([]IPRow, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT ip, first_seen, last_seen, source, COALESCE(country,''), COALESCE(city,''), COALESCE(asn,'')
+FROM user_ip_history
+WHERE user_id = $1
+ORDER BY last_seen DESC
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []IPRow
+	for rows.Next() {
+		var x IPRow
+		if err := rows.Scan(&x.IP, &x.FirstSeen, &x.LastSeen, &x.Source, &x.Country, &x.City, &x.ASN); err != nil {
+			return nil, err
+		}
+		list = append(list, x)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) ListIPsMissingGeo(ctx context.Context, limit int) ([]IPGeoTarget, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, ip FROM user_ip_history WHERE country IS NULL OR country = '' LIMIT $1
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []IPGeoTarget
+	for rows.Next() {
+		var t IPGeoTarget
+		if err := rows.Scan(&t.ID, &t.IP); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) UpdateIPGeo(ctx context.Context, id int64, country, city, asn string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_ip_history SET country = $1, city = $2, asn = $3 WHERE id = $4`, country, city, asn, id)
+	return err
+}
+
+func (s *PostgresStore) Username(ctx context.Context, userID int64) (string, error) {
+	var username string
+	err := s.db.QueryRowContext(ctx, `SELECT username FROM plex_users WHERE id = $1`, userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return username, err
+}
+
+func (s *PostgresStore) CountUsers(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM plex_users`).Scan(&n)
+	return n, err
+}
+
+func (s *PostgresStore) CountIPHistoryRows(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_ip_history`).Scan(&n)
+	return n, err
+}
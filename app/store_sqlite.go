@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is the default Store backend: a single-writer SQLite database.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(dbPath string) (*SQLiteStore, error) {
+	// SQLite DSN met WAL + busy_timeout
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", dbPath)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // SQLite: single-writer
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) DB() *sql.DB { return s.db }
+
+func (s *SQLiteStore) EnsureSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS plex_users (
+  id            INTEGER PRIMARY KEY,         -- user_id
+  username      TEXT NOT NULL,
+  friendly_name TEXT,
+  user_thumb    TEXT,
+  last_seen     TEXT NOT NULL               -- RFC3339
+);
+
+CREATE TABLE IF NOT EXISTS user_ip_history (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id    INTEGER NOT NULL,
+  ip         TEXT NOT NULL,
+  first_seen TEXT NOT NULL,
+  last_seen  TEXT NOT NULL,
+  CONSTRAINT uq_user_ip UNIQUE (user_id, ip),
+  FOREIGN KEY(user_id) REFERENCES plex_users(id) ON DELETE CASCADE
+);
+`)
+	if err != nil {
+		return err
+	}
+
+	// SQLite has no "ADD COLUMN IF NOT EXISTS"; CREATE TABLE IF NOT EXISTS above
+	// is a no-op against a pre-existing database, so these columns (added after
+	// the tables were first created) have to be migrated in by hand.
+	migrations := []struct{ table, column, ddl string }{
+		{"plex_users", "last_source", "ALTER TABLE plex_users ADD COLUMN last_source TEXT"},
+		{"user_ip_history", "source", "ALTER TABLE user_ip_history ADD COLUMN source TEXT NOT NULL DEFAULT 'ingest'"},
+		{"user_ip_history", "country", "ALTER TABLE user_ip_history ADD COLUMN country TEXT"},
+		{"user_ip_history", "city", "ALTER TABLE user_ip_history ADD COLUMN city TEXT"},
+		{"user_ip_history", "asn", "ALTER TABLE user_ip_history ADD COLUMN asn TEXT"},
+	}
+	for _, m := range migrations {
+		if err := addColumnIfMissing(ctx, s.db, m.table, m.column, m.ddl); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+CREATE INDEX IF NOT EXISTS idx_user_ip_history_user_last
+ON user_ip_history (user_id, last_seen DESC);
+
+-- Recreated every time: a view left over from before last_source/country
+-- existed would otherwise survive and break ListSummary.
+DROP VIEW IF EXISTS users_last_ip;
+CREATE VIEW users_last_ip AS
+SELECT
+  pu.id AS user_id,
+  pu.username,
+  pu.friendly_name,
+  pu.last_source,
+  (
+    SELECT uih.ip
+    FROM user_ip_history uih
+    WHERE uih.user_id = pu.id
+    ORDER BY uih.last_seen DESC
+    LIMIT 1
+  ) AS last_ip,
+  (
+    SELECT uih.last_seen
+    FROM user_ip_history uih
+    WHERE uih.user_id = pu.id
+    ORDER BY uih.last_seen DESC
+    LIMIT 1
+  ) AS updated_at,
+  (
+    SELECT uih.country
+    FROM user_ip_history uih
+    WHERE uih.user_id = pu.id
+    ORDER BY uih.last_seen DESC
+    LIMIT 1
+  ) AS country
+FROM plex_users pu;
+`)
+	return err
+}
+
+// addColumnIfMissing adds column to table via ddl unless it's already present.
+// SQLite (unlike Postgres) has no ADD COLUMN IF NOT EXISTS, so upgrading an
+// existing database needs this explicit check instead.
+func addColumnIfMissing(ctx context.Context, db *sql.DB, table, column, ddl string) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT 1 FROM pragma_table_info(%q) WHERE name = ?", table), column)
+	if err != nil {
+		return fmt.Errorf("checking %s.%s: %w", table, column, err)
+	}
+	exists := rows.Next()
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("checking %s.%s: %w", table, column, err)
+	}
+	rows.Close()
+	if exists {
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return fmt.Errorf("migrating %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UpsertUser(ctx context.Context, it TautulliItem, seenAt time.Time, source string) error {
+	return sqliteUpsertUser(ctx, s.db, it, seenAt, source)
+}
+
+func (s *SQLiteStore) UpsertIP(ctx context.Context, userID int64, ip string, seenAt time.Time, source string) error {
+	return sqliteUpsertIP(ctx, s.db, userID, ip, seenAt, source, geoInfo{})
+}
+
+func sqliteUpsertUser(ctx context.Context, ex execer, it TautulliItem, seenAt time.Time, source string) error {
+	_, err := ex.ExecContext(ctx, `
+INSERT INTO plex_users (id, username, friendly_name, user_thumb, last_seen, last_source)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+  username=excluded.username,
+  friendly_name=excluded.friendly_name,
+  user_thumb=excluded.user_thumb,
+  last_seen=CASE WHEN plex_users.last_seen > excluded.last_seen THEN plex_users.last_seen ELSE excluded.last_seen END,
+  last_source=CASE WHEN plex_users.last_seen > excluded.last_seen THEN plex_users.last_source ELSE excluded.last_source END
+`, it.UserID, it.User, it.FriendlyName, it.UserThumb, seenAt.Format(time.RFC3339), source)
+	if err != nil {
+		return fmt.Errorf("user upsert: %w", err)
+	}
+	return nil
+}
+
+func sqliteUpsertIP(ctx context.Context, ex execer, userID int64, ip string, seenAt time.Time, source string, geo geoInfo) error {
+	ts := seenAt.Format(time.RFC3339)
+	_, err := ex.ExecContext(ctx, `
+INSERT INTO user_ip_history (user_id, ip, first_seen, last_seen, source, country, city, asn)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id, ip) DO UPDATE SET
+  last_seen=CASE WHEN user_ip_history.last_seen > excluded.last_seen THEN user_ip_history.last_seen ELSE excluded.last_seen END,
+  source=CASE WHEN user_ip_history.last_seen > excluded.last_seen THEN user_ip_history.source ELSE excluded.source END,
+  country=CASE WHEN excluded.country <> '' THEN excluded.country ELSE user_ip_history.country END,
+  city=CASE WHEN excluded.city <> '' THEN excluded.city ELSE user_ip_history.city END,
+  asn=CASE WHEN excluded.asn <> '' THEN excluded.asn ELSE user_ip_history.asn END
+`, userID, ip, ts, ts, source, geo.Country, geo.City, geo.ASN)
+	if err != nil {
+		return fmt.Errorf("ip upsert: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) IngestBatch(ctx context.Context, arr []TautulliItem) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, it := range arr {
+		ts := seenAtFor(it)
+		source := it.Source
+		if source == "" {
+			source = "ingest"
+		}
+		if err := sqliteUpsertUser(ctx, tx, it, ts, source); err != nil {
+			return err
+		}
+		if it.IPAddress != nil && *it.IPAddress != "" {
+			geo := geoInfo{Country: it.Country, City: it.City, ASN: it.ASN}
+			if err := sqliteUpsertIP(ctx, tx, it.UserID, *it.IPAddress, ts, source, geo); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListSummary(ctx context.Context, q, country string) ([]SummaryRow, error) {
+	sqlq := `
+SELECT pu.id, pu.username, pu.friendly_name, uli.last_ip, uli.updated_at, uli.last_source, uli.country
+FROM users_last_ip uli
+JOIN plex_users pu ON pu.id = uli.user_id
+`
+	var where []string
+	args := []any{}
+	if q != "" {
+		where = append(where, "(pu.username LIKE ? OR IFNULL(uli.last_ip,'') LIKE ? OR IFNULL(pu.friendly_name,'') LIKE ?)")
+		p := "%" + q + "%"
+		args = append(args, p, p, p)
+	}
+	if country != "" {
+		where = append(where, "uli.country = ?")
+		args = append(args, country)
+	}
+	if len(where) > 0 {
+		sqlq += "WHERE " + strings.Join(where, " AND ") + "\n"
+	}
+	sqlq += "ORDER BY pu.username ASC"
+
+	rows, err := s.db.QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []SummaryRow
+	for rows.Next() {
+		var r SummaryRow
+		if err := rows.Scan(&r.UserID, &r.Username, &r.FriendlyName, &r.LastIP, &r.UpdatedAt, &r.LastSource, &r.Country); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) ListUserIPs(ctx context.Context, userID int64) ([]IPRow, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT ip, first_seen, last_seen, source, IFNULL(country,''), IFNULL(city,''), IFNULL(asn,'')
+FROM user_ip_history
+WHERE user_id = ?
+ORDER BY last_seen DESC
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []IPRow
+	for rows.Next() {
+		var x IPRow
+		if err := rows.Scan(&x.IP, &x.FirstSeen, &x.LastSeen, &x.Source, &x.Country, &x.City, &x.ASN); err != nil {
+			return nil, err
+		}
+		list = append(list, x)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) ListIPsMissingGeo(ctx context.Context, limit int) ([]IPGeoTarget, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, ip FROM user_ip_history WHERE country IS NULL OR country = '' LIMIT ?
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []IPGeoTarget
+	for rows.Next() {
+		var t IPGeoTarget
+		if err := rows.Scan(&t.ID, &t.IP); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateIPGeo(ctx context.Context, id int64, country, city, asn string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE user_ip_history SET country = ?, city = ?, asn = ? WHERE id = ?`, country, city, asn, id)
+	return err
+}
+
+func (s *SQLiteStore) Username(ctx context.Context, userID int64) (string, error) {
+	var username string
+	err := s.db.QueryRowContext(ctx, `SELECT username FROM plex_users WHERE id = ?`, userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return username, err
+}
+
+func (s *SQLiteStore) CountUsers(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM plex_users`).Scan(&n)
+	return n, err
+}
+
+func (s *SQLiteStore) CountIPHistoryRows(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_ip_history`).Scan(&n)
+	return n, err
+}